@@ -0,0 +1,38 @@
+// Package billing abstracts the customer invoicing lifecycle behind the Invoicer interface so
+// the charge loop can target Stripe, a dry-run backend for tests, or another payment processor
+// without change.
+package billing
+
+// LineItem is one charge to attach to a customer's next invoice
+type LineItem struct {
+	IdempotencyKey string
+	Amount         int64 // in the currency's smallest unit, e.g. cents for usd
+	CurrencyCode   string
+	Description    string
+	VATBasisPoints int64 // rate*100000, e.g. 20000 means 20% - zero means no tax
+}
+
+// Invoice is a minimal, backend-agnostic view of an invoice
+type Invoice struct {
+	ID         string
+	CustomerID string
+	Status     string
+	Created    int64
+}
+
+// Invoicer is implemented by anything capable of running a customer through the
+// add-items/finalize/pay lifecycle, and by whatever's needed to correct drift after the fact
+type Invoicer interface {
+	// AddLineItem attaches a charge to the customer's next invoice, returning the created
+	// line item's ID so callers can persist it for reconciliation
+	AddLineItem(customerID string, item LineItem) (string, error)
+	// FinalizeInvoice creates the invoice covering everything added via AddLineItem for the
+	// customer/currency pair since the last invoice, returning its ID
+	FinalizeInvoice(customerID string, currencyCode string, idempotencyKey string) (string, error)
+	// PayInvoice attempts to collect payment for an existing invoice
+	PayInvoice(invoiceID string) error
+	// SetInvoiceStatus voids or marks an invoice uncollectible
+	SetInvoiceStatus(invoiceID string, status string) error
+	// ListOpenInvoices returns invoices still awaiting payment for a customer
+	ListOpenInvoices(customerID string) ([]Invoice, error)
+}
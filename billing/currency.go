@@ -0,0 +1,28 @@
+package billing
+
+import "math"
+
+// currencyExponents holds the ISO 4217 minor unit exponent for currencies that don't use the
+// default of 2 (e.g. JPY has no minor unit, BHD has three) - see
+// https://stripe.com/docs/currencies#zero-decimal and #three-decimal
+var currencyExponents = map[string]int{
+	"bhd": 3, "jod": 3, "kwd": 3, "omr": 3, "tnd": 3,
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "isk": 0, "jpy": 0, "krw": 0,
+	"pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0, "vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+}
+
+// currencyExponent returns the number of digits after the decimal point Stripe expects for
+// the given lowercase currency code, defaulting to 2 for currencies not in currencyExponents
+func currencyExponent(currencyCode string) int {
+	if exp, ok := currencyExponents[currencyCode]; ok {
+		return exp
+	}
+
+	return 2
+}
+
+// ToMinorUnits converts a decimal amount into the smallest currency unit Stripe expects
+// (e.g. cents for usd, whole yen for jpy)
+func ToMinorUnits(amount float64, currencyCode string) int64 {
+	return int64(math.Round(amount * math.Pow(10, float64(currencyExponent(currencyCode)))))
+}
@@ -0,0 +1,45 @@
+package billing
+
+import "testing"
+
+func TestToMinorUnits(t *testing.T) {
+	tests := []struct {
+		name         string
+		amount       float64
+		currencyCode string
+		want         int64
+	}{
+		{"usd rounds to cents", 19.99, "usd", 1999},
+		{"usd rounds half up", 10.005, "usd", 1001},
+		{"jpy has no minor unit", 1500, "jpy", 1500},
+		{"bhd has three decimal digits", 19.999, "bhd", 19999},
+		{"unknown currency defaults to two decimals", 5.5, "xyz", 550},
+		{"zero amount", 0, "usd", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToMinorUnits(tt.amount, tt.currencyCode); got != tt.want {
+				t.Errorf("ToMinorUnits(%v, %q) = %d, want %d", tt.amount, tt.currencyCode, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVatPercent(t *testing.T) {
+	tests := []struct {
+		basisPoints int64
+		want        float64
+	}{
+		{0, 0},
+		{20000, 20},
+		{55000, 55},
+		{8500, 8.5},
+	}
+
+	for _, tt := range tests {
+		if got := vatPercent(tt.basisPoints); got != tt.want {
+			t.Errorf("vatPercent(%d) = %v, want %v", tt.basisPoints, got, tt.want)
+		}
+	}
+}
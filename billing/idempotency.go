@@ -0,0 +1,14 @@
+package billing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// IdempotencyKey derives a deterministic Stripe idempotency key from the given parts, so
+// re-running a charge for the same inputs never double-bills
+func IdempotencyKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package billing
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v71"
+)
+
+// retryConfig controls the bounded exponential backoff (plus jitter) applied around Stripe calls
+type retryConfig struct {
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	maxAttempts    int
+}
+
+// retryConfigFromEnv builds a retryConfig from RETRY_* env vars, falling back to sane defaults
+// for anything unset or unparseable
+func retryConfigFromEnv() retryConfig {
+	cfg := retryConfig{
+		initialBackoff: 500 * time.Millisecond,
+		maxBackoff:     30 * time.Second,
+		multiplier:     2.0,
+		maxAttempts:    5,
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("RETRY_INITIAL_BACKOFF_MS")); err == nil {
+		cfg.initialBackoff = time.Duration(v) * time.Millisecond
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("RETRY_MAX_BACKOFF_MS")); err == nil {
+		cfg.maxBackoff = time.Duration(v) * time.Millisecond
+	}
+
+	if v, err := strconv.ParseFloat(os.Getenv("RETRY_MULTIPLIER"), 64); err == nil {
+		cfg.multiplier = v
+	}
+
+	if v, err := strconv.Atoi(os.Getenv("RETRY_MAX_ATTEMPTS")); err == nil {
+		cfg.maxAttempts = v
+	}
+
+	return cfg
+}
+
+// isRetryableStripeErr reports whether err represents a transient Stripe failure worth
+// retrying - API/connection errors and 5xx/429 responses
+func isRetryableStripeErr(err error) bool {
+	stripeErr, ok := err.(*stripe.Error)
+	if !ok {
+		return false
+	}
+
+	switch stripeErr.Type {
+	case stripe.ErrorTypeAPI, stripe.ErrorTypeAPIConnection:
+		return true
+	}
+
+	return stripeErr.HTTPStatusCode == http.StatusTooManyRequests || stripeErr.HTTPStatusCode >= http.StatusInternalServerError
+}
+
+// withRetry runs fn, retrying transient Stripe errors with a jittered exponential backoff
+// (backoff = min + rand*(max-min), where max grows as initialBackoff*multiplier^attempt,
+// capped at maxBackoff) until it succeeds, a non-retryable error occurs, or attempts are exhausted
+func withRetry(cfg retryConfig, fn func() error) error {
+	var err error
+
+	for attempt := 0; attempt < cfg.maxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if !isRetryableStripeErr(err) {
+			return err
+		}
+
+		if attempt == cfg.maxAttempts-1 {
+			break
+		}
+
+		max := time.Duration(float64(cfg.initialBackoff) * math.Pow(cfg.multiplier, float64(attempt)))
+		if max > cfg.maxBackoff {
+			max = cfg.maxBackoff
+		}
+
+		backoff := cfg.initialBackoff + time.Duration(rand.Int63n(int64(max-cfg.initialBackoff)+1))
+		if backoff > cfg.maxBackoff {
+			backoff = cfg.maxBackoff
+		}
+
+		log.Printf("stripe call failed (attempt %d/%d), retrying in %s: %v", attempt+1, cfg.maxAttempts, backoff, err)
+		time.Sleep(backoff)
+	}
+
+	return err
+}
@@ -0,0 +1,133 @@
+package billing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v71"
+)
+
+func testRetryConfig() retryConfig {
+	return retryConfig{
+		initialBackoff: time.Millisecond,
+		maxBackoff:     5 * time.Millisecond,
+		multiplier:     2.0,
+		maxAttempts:    3,
+	}
+}
+
+func TestWithRetrySucceedsFirstTry(t *testing.T) {
+	calls := 0
+	err := withRetry(testRetryConfig(), func() error {
+		calls++
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("withRetry() = %v, want nil", err)
+	}
+
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestWithRetryRetriesTransientErrors(t *testing.T) {
+	calls := 0
+	transient := &stripe.Error{Type: stripe.ErrorTypeAPIConnection}
+
+	err := withRetry(testRetryConfig(), func() error {
+		calls++
+		if calls < 3 {
+			return transient
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("withRetry() = %v, want nil", err)
+	}
+
+	if calls != 3 {
+		t.Fatalf("fn called %d times, want 3", calls)
+	}
+}
+
+func TestWithRetryStopsOnNonRetryableError(t *testing.T) {
+	calls := 0
+	permanent := &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400}
+
+	err := withRetry(testRetryConfig(), func() error {
+		calls++
+		return permanent
+	})
+
+	if err != permanent {
+		t.Fatalf("withRetry() = %v, want %v", err, permanent)
+	}
+
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1 - non-retryable errors shouldn't be retried", calls)
+	}
+}
+
+func TestWithRetryExhaustsAttempts(t *testing.T) {
+	calls := 0
+	transient := &stripe.Error{Type: stripe.ErrorTypeRateLimit, HTTPStatusCode: 429}
+
+	cfg := testRetryConfig()
+	err := withRetry(cfg, func() error {
+		calls++
+		return transient
+	})
+
+	if err != transient {
+		t.Fatalf("withRetry() = %v, want %v", err, transient)
+	}
+
+	if calls != cfg.maxAttempts {
+		t.Fatalf("fn called %d times, want %d", calls, cfg.maxAttempts)
+	}
+}
+
+func TestWithRetryNonStripeErrorIsNotRetried(t *testing.T) {
+	calls := 0
+	plain := errors.New("boom")
+
+	err := withRetry(testRetryConfig(), func() error {
+		calls++
+		return plain
+	})
+
+	if err != plain {
+		t.Fatalf("withRetry() = %v, want %v", err, plain)
+	}
+
+	if calls != 1 {
+		t.Fatalf("fn called %d times, want 1", calls)
+	}
+}
+
+func TestIsRetryableStripeErr(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"api error", &stripe.Error{Type: stripe.ErrorTypeAPI}, true},
+		{"api connection error", &stripe.Error{Type: stripe.ErrorTypeAPIConnection}, true},
+		{"rate limited", &stripe.Error{Type: stripe.ErrorTypeRateLimit, HTTPStatusCode: 429}, true},
+		{"server error", &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 500}, true},
+		{"invalid request", &stripe.Error{Type: stripe.ErrorTypeInvalidRequest, HTTPStatusCode: 400}, false},
+		{"non-stripe error", errors.New("boom"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableStripeErr(tt.err); got != tt.want {
+				t.Errorf("isRetryableStripeErr(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package billing
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/stripe/stripe-go/v71"
+	"github.com/stripe/stripe-go/v71/invoice"
+	"github.com/stripe/stripe-go/v71/invoiceitem"
+	"github.com/stripe/stripe-go/v71/taxrate"
+
+	"github.com/dnoberon/charger/ratelimit"
+)
+
+// taxRateCacheMu guards taxRateCache, since chargeAndRecordCustomer's worker pool charges
+// multiple customer/currency pairs against a single StripeInvoicer concurrently, and any of
+// them can be the first to need a given VAT rate
+var taxRateCacheMu sync.Mutex
+
+// taxRateCache maps a VAT rate (basis points, e.g. 20000 == 20%) to the Stripe TaxRate ID
+// created for it, so repeated rates across items/loop iterations reuse a single Stripe object
+var taxRateCache = map[int64]string{}
+
+// vatPercent converts a basis-point VAT rate (rate*100000, e.g. 20000 means 20%) into the
+// percentage value Stripe's TaxRate API expects
+func vatPercent(vatBasisPoints int64) float64 {
+	return float64(vatBasisPoints) / 1000
+}
+
+// getOrCreateTaxRate looks up a cached Stripe TaxRate for the given VAT rate, creating one on
+// demand if this is the first time we've seen it - throttle is called before creating one
+func getOrCreateTaxRate(vatBasisPoints int64, throttle func()) (string, error) {
+	taxRateCacheMu.Lock()
+	defer taxRateCacheMu.Unlock()
+
+	if id, ok := taxRateCache[vatBasisPoints]; ok {
+		return id, nil
+	}
+
+	percent := vatPercent(vatBasisPoints)
+	displayName := fmt.Sprintf("VAT %.3f%%", percent)
+
+	throttle()
+	rate, err := taxrate.New(&stripe.TaxRateParams{
+		DisplayName: stripe.String(displayName),
+		Percentage:  stripe.Float64(percent),
+		Inclusive:   stripe.Bool(false),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	taxRateCache[vatBasisPoints] = rate.ID
+
+	return rate.ID, nil
+}
+
+// StripeInvoicer is the Invoicer backend that talks to the real Stripe API
+type StripeInvoicer struct {
+	retry   retryConfig
+	limiter *ratelimit.Limiter
+}
+
+var _ Invoicer = (*StripeInvoicer)(nil)
+
+// Option configures a StripeInvoicer at construction time
+type Option func(*StripeInvoicer)
+
+// WithRateLimiter throttles every Stripe call the StripeInvoicer makes through l, so
+// parallelized callers don't blow through Stripe's API quota. ListOpenInvoices waits once per
+// listing, not once per page of results.
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return func(s *StripeInvoicer) {
+		s.limiter = l
+	}
+}
+
+// NewStripeInvoicer configures the Stripe client from STRIPE_API_KEY and returns an Invoicer
+// backed by it
+func NewStripeInvoicer(opts ...Option) *StripeInvoicer {
+	stripe.Key = os.Getenv("STRIPE_API_KEY")
+
+	s := &StripeInvoicer{retry: retryConfigFromEnv()}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// throttle blocks until the configured rate limiter (if any) allows another Stripe call
+func (s *StripeInvoicer) throttle() {
+	if s.limiter != nil {
+		s.limiter.Wait()
+	}
+}
+
+func (s *StripeInvoicer) AddLineItem(customerID string, item LineItem) (string, error) {
+	if item.Amount <= 0 {
+		return "", nil
+	}
+
+	params := &stripe.InvoiceItemParams{
+		Params:      stripe.Params{IdempotencyKey: stripe.String(item.IdempotencyKey)},
+		Customer:    stripe.String(customerID),
+		Amount:      stripe.Int64(item.Amount),
+		Currency:    stripe.String(item.CurrencyCode),
+		Description: stripe.String(item.Description),
+	}
+
+	if item.VATBasisPoints > 0 {
+		taxRateID, err := getOrCreateTaxRate(item.VATBasisPoints, s.throttle)
+		if err != nil {
+			return "", err
+		}
+
+		params.TaxRates = []*string{stripe.String(taxRateID)}
+	}
+
+	var ii *stripe.InvoiceItem
+
+	err := withRetry(s.retry, func() error {
+		s.throttle()
+		var err error
+		ii, err = invoiceitem.New(params)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return ii.ID, nil
+}
+
+// FinalizeInvoice creates an invoice for the customer. currencyCode is not passed to Stripe, so
+// the invoice is not restricted to that currency: it covers every item pending for the customer.
+func (s *StripeInvoicer) FinalizeInvoice(customerID string, currencyCode string, idempotencyKey string) (string, error) {
+	var in *stripe.Invoice
+
+	err := withRetry(s.retry, func() error {
+		s.throttle()
+		var err error
+		in, err = invoice.New(&stripe.InvoiceParams{
+			Params:           stripe.Params{IdempotencyKey: stripe.String(idempotencyKey)},
+			Customer:         stripe.String(customerID),
+			AutoAdvance:      stripe.Bool(true),
+			CollectionMethod: stripe.String("send_invoice"),
+			DaysUntilDue:     stripe.Int64(30),
+			Description:      stripe.String("Weekly cleaning and item replacement charges for properties managed."),
+		})
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return in.ID, nil
+}
+
+func (s *StripeInvoicer) PayInvoice(invoiceID string) error {
+	return withRetry(s.retry, func() error {
+		s.throttle()
+		_, err := invoice.Pay(invoiceID, nil)
+		return err
+	})
+}
+
+func (s *StripeInvoicer) SetInvoiceStatus(invoiceID string, status string) error {
+	return withRetry(s.retry, func() error {
+		s.throttle()
+		var err error
+
+		switch status {
+		case "void":
+			_, err = invoice.VoidInvoice(invoiceID, nil)
+		case "uncollectible":
+			_, err = invoice.MarkUncollectible(invoiceID, nil)
+		default:
+			return fmt.Errorf("unsupported invoice status %q", status)
+		}
+
+		return err
+	})
+}
+
+// ListOpenInvoices lists open invoices for customerID, or across all customers if customerID
+// is empty
+func (s *StripeInvoicer) ListOpenInvoices(customerID string) ([]Invoice, error) {
+	params := &stripe.InvoiceListParams{
+		Status: stripe.String("open"),
+	}
+
+	if customerID != "" {
+		params.Customer = stripe.String(customerID)
+	}
+
+	s.throttle()
+
+	var invoices []Invoice
+
+	it := invoice.List(params)
+	for it.Next() {
+		in := it.Invoice()
+
+		invID := customerID
+		if in.Customer != nil {
+			invID = in.Customer.ID
+		}
+
+		invoices = append(invoices, Invoice{
+			ID:         in.ID,
+			CustomerID: invID,
+			Status:     string(in.Status),
+			Created:    in.Created,
+		})
+	}
+
+	return invoices, it.Err()
+}
@@ -0,0 +1,430 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dnoberon/charger/airtable"
+	"github.com/dnoberon/charger/billing"
+	"github.com/dnoberon/charger/ratelimit"
+	"github.com/dnoberon/charger/records"
+	"github.com/dnoberon/charger/store"
+)
+
+// apiLimiter throttles Airtable calls made by this package. It's nil (no throttling) unless
+// the active subcommand opts in, e.g. "run" sets it once worker pools make Airtable calls
+// concurrent.
+var apiLimiter *ratelimit.Limiter
+
+// throttleAirtable blocks until apiLimiter (if configured) allows another Airtable call
+func throttleAirtable() {
+	if apiLimiter != nil {
+		apiLimiter.Wait()
+	}
+}
+
+// InvoiceItem is a single Airtable row that's due to be billed
+type InvoiceItem struct {
+	item             string
+	quantity         int64
+	amount           float64
+	currencyCode     string
+	property         string
+	airtableRecordID string
+	dateServiced     time.Time
+	vatBasisPoints   int64
+}
+
+// customerCurrency groups invoice items so that a single Stripe invoice never mixes
+// currencies - Stripe rejects invoices whose line items don't share a currency
+type customerCurrency struct {
+	customerID   string
+	currencyCode string
+}
+
+// needed to sort the invoices by time serviced
+type invoiceByTime []InvoiceItem
+
+func (p invoiceByTime) Len() int {
+	return len(p)
+}
+
+func (p invoiceByTime) Less(i, j int) bool {
+	return p[i].dateServiced.Before(p[j].dateServiced)
+}
+
+func (p invoiceByTime) Swap(i, j int) {
+	p[i], p[j] = p[j], p[i]
+}
+
+// fetchAirtableRecords fetches a page of Airtable records matching filterByFormula, accepting
+// an offset parameter if the previous response isn't the end of the records
+func fetchAirtableRecords(filterByFormula string, offset string) (airtable.ListResponse, error) {
+	options := airtable.ListRecordsOptions{
+		TableName: os.Getenv("TABLENAME"),
+		Fields: []string{
+			os.Getenv("STRIPE_CUSTOMER_ID_COLUMN"),
+			os.Getenv("INVOICE_AMOUNT_COLUMN"),
+			os.Getenv("PAID_COLUMN"),
+			os.Getenv("CURRENCY_CODE_COLUMN"),
+			os.Getenv("DATE_COLUMN"),
+			os.Getenv("QUANTITY_COLUMN"),
+			os.Getenv("ITEM_COLUMN"),
+			os.Getenv("PROPERTY_COLUMN"),
+			os.Getenv("DATE_SERVICED_COLUMN"),
+			os.Getenv("VAT_COLUMN"),
+		},
+		FilterByFormula: filterByFormula,
+		PageSize:        100, // max records return allowed from airtable
+	}
+
+	if offset != "" {
+		options.Offset = offset
+	}
+
+	airtableClient, err := airtable.NewAirtableClient(os.Getenv("AIRTABLE_API_KEY"), os.Getenv("AIRTABLE_BASE_ID"))
+	if err != nil {
+		return airtable.ListResponse{}, err
+	}
+
+	throttleAirtable()
+
+	return airtableClient.ListFromTable(options)
+}
+
+// fetchAllAirtableRecords pages through fetchAirtableRecords until Airtable stops returning an offset
+func fetchAllAirtableRecords(filterByFormula string) ([]airtable.Record, error) {
+	offset := ""
+	recs := []airtable.Record{}
+
+	for {
+		results, err := fetchAirtableRecords(filterByFormula, offset)
+		if err != nil {
+			return recs, err
+		}
+
+		recs = append(recs, results.Records...)
+
+		if results.Offset == "" {
+			return recs, nil
+		}
+
+		offset = results.Offset
+	}
+}
+
+// dueInvoicesFilter is the FilterByFormula used by "run" to select rows that are unpaid and
+// not already mid-charge
+func dueInvoicesFilter() string {
+	paidColumn := os.Getenv("PAID_COLUMN")
+	return fmt.Sprintf(`AND(NOT({%s} = 'true'), NOT({%s} = 'false'), NOT({%s} = 'charging'))`, paidColumn, paidColumn, paidColumn)
+}
+
+// chargingInvoicesFilter is the FilterByFormula used by "finalize-invoices" to select rows
+// staged as "charging" by a prior run that never completed
+func chargingInvoicesFilter() string {
+	return fmt.Sprintf(`{%s} = 'charging'`, os.Getenv("PAID_COLUMN"))
+}
+
+// groupInvoiceItems parses Airtable records into InvoiceItems keyed by (customer, currency).
+// When enforceDueDate is true, rows whose due date is in the future or older than staleDays are
+// skipped - "run" enforces this, "finalize-invoices" doesn't since those rows already passed the
+// gate during the run that staged them.
+func groupInvoiceItems(recs []airtable.Record, staleDays int, enforceDueDate bool) map[customerCurrency][]InvoiceItem {
+	stripeCustomerIDColumn := os.Getenv("STRIPE_CUSTOMER_ID_COLUMN")
+	invoiceAmountColumn := os.Getenv("INVOICE_AMOUNT_COLUMN")
+	currencyCodeColumn := os.Getenv("CURRENCY_CODE_COLUMN")
+	dateColumn := os.Getenv("DATE_COLUMN")
+	quantityColumn := os.Getenv("QUANTITY_COLUMN")
+	itemColumn := os.Getenv("ITEM_COLUMN")
+	propertyColumn := os.Getenv("PROPERTY_COLUMN")
+	dateServicedColumn := os.Getenv("DATE_SERVICED_COLUMN")
+	vatColumn := os.Getenv("VAT_COLUMN")
+
+	customerInvoices := map[customerCurrency][]InvoiceItem{}
+
+	for _, record := range recs {
+		invoice := InvoiceItem{}
+
+		loc, err := time.LoadLocation(os.Getenv("TIMEZONE"))
+		if err != nil {
+			log.Fatal("incorrect time location!")
+			continue
+		}
+
+		if enforceDueDate {
+			val, ok := record.Fields[dateColumn]
+			if !ok {
+				log.Printf("date not present, skipping")
+				continue
+			}
+
+			date, err := time.ParseInLocation("2006-01-02", fmt.Sprintf("%v", val), loc)
+			if err == nil {
+				if !time.Now().In(loc).After(date) {
+					log.Printf("date in future, skipping")
+					continue
+				}
+
+				past := time.Now().In(loc).AddDate(0, 0, staleDays)
+
+				if date.Before(past) {
+					log.Printf("pay date too old, skipping")
+					continue
+				}
+			}
+		}
+
+		val, ok := record.Fields[dateServicedColumn]
+		if !ok {
+			log.Printf("service date not present, skipping")
+			continue
+		}
+
+		serviceDate, err := time.ParseInLocation("2006-01-02", fmt.Sprintf("%v", val), loc)
+		if err != nil {
+			log.Printf("error parsing service date")
+			continue
+		}
+
+		invoice.dateServiced = serviceDate
+
+		customerID, ok := records.RollupString(record.Fields, stripeCustomerIDColumn)
+		if !ok {
+			log.Printf("customerID not present, skipping")
+			continue
+		}
+
+		currencyCode, ok := records.StringField(record.Fields, currencyCodeColumn)
+		if !ok {
+			log.Printf("currency code not present, skipping")
+			continue
+		}
+
+		invoice.currencyCode = strings.ToLower(currencyCode)
+
+		amount, ok := records.NumberField(record.Fields, invoiceAmountColumn)
+		if !ok {
+			log.Printf("invoice amount not present, skipping")
+			continue
+		}
+
+		if amount <= 0 {
+			log.Printf("invoice amount not greater than 0, skipping")
+			continue
+		}
+
+		invoice.amount = amount
+
+		quantity, ok := records.IntField(record.Fields, quantityColumn)
+		if !ok {
+			log.Printf("quantity not present, skipping")
+			continue
+		}
+
+		invoice.quantity = quantity
+
+		item, ok := records.StringField(record.Fields, itemColumn)
+		if !ok {
+			log.Printf("item not present, skipping")
+			continue
+		}
+
+		invoice.item = item
+
+		propertyName, ok := records.StringField(record.Fields, propertyColumn)
+		if !ok {
+			log.Printf("property not present, skipping")
+			continue
+		}
+
+		invoice.property = propertyName
+
+		// VAT is optional - rows without it are simply billed at their net amount
+		if vat, ok := records.IntField(record.Fields, vatColumn); ok {
+			invoice.vatBasisPoints = vat
+		}
+
+		invoice.airtableRecordID = record.ID
+		key := customerCurrency{customerID: customerID, currencyCode: invoice.currencyCode}
+		customerInvoices[key] = append(customerInvoices[key], invoice)
+	}
+
+	return customerInvoices
+}
+
+// defaultStorePath is used when CHARGER_STORE_PATH isn't set
+const defaultStorePath = "charger.db"
+
+// openChargeStore opens the local charge store at CHARGER_STORE_PATH, or defaultStorePath if unset
+func openChargeStore() (*store.Store, error) {
+	path := os.Getenv("CHARGER_STORE_PATH")
+	if path == "" {
+		path = defaultStorePath
+	}
+
+	return store.Open(path)
+}
+
+// partialUpdater is satisfied by the Airtable client returned from airtable.NewAirtableClient
+type partialUpdater interface {
+	PartialUpdate(airtable.PartialUpdateOptions, airtable.Record) error
+}
+
+// partialUpdate throttles and applies an Airtable PartialUpdate, used by run and
+// finalize-invoices to write back paid/notes columns
+func partialUpdate(client partialUpdater, tableName string, record airtable.Record) error {
+	throttleAirtable()
+	return client.PartialUpdate(airtable.PartialUpdateOptions{TableName: tableName}, record)
+}
+
+// earliestServiceDate returns the earliest dateServiced across items, used to key the invoice's
+// idempotency key so retries of the same billing period never create a second invoice
+func earliestServiceDate(items []InvoiceItem) time.Time {
+	earliest := items[0].dateServiced
+	for _, item := range items[1:] {
+		if item.dateServiced.Before(earliest) {
+			earliest = item.dateServiced
+		}
+	}
+
+	return earliest
+}
+
+// alreadyCharged reports whether st already has a fully-charged record for airtableRecordID -
+// checked before every Stripe call so a failed Airtable write after a successful charge can
+// never cause a double-charge on the next run. A nil store always reports false.
+func alreadyCharged(st *store.Store, airtableRecordID string) bool {
+	if st == nil {
+		return false
+	}
+
+	rec, found, err := st.Get(airtableRecordID)
+	if err != nil {
+		log.Printf("unable to read charge record for airtable record %s - %v", airtableRecordID, err)
+		return false
+	}
+
+	return found && rec.Status == store.StatusCharged
+}
+
+// recordCharge atomically persists rec to st, if a store was configured
+func recordCharge(st *store.Store, rec store.ChargeRecord) {
+	if st == nil {
+		return
+	}
+
+	if err := st.Put(rec); err != nil {
+		log.Printf("unable to persist charge record for airtable record %s - %v", rec.AirtableRecordID, err)
+	}
+}
+
+// chargeOutcome reports what a chargeCustomer call actually did to items, so callers never
+// write Airtable's paid column, or the local store, for a row that wasn't really covered by
+// invoiceID. charged is the subset of items newly added to Stripe and finalized onto invoiceID
+// this call; failed is the subset whose AddLineItem call errored and were excluded from the
+// invoice entirely. Items that were already charged under an earlier invoice appear in neither
+// slice - this call didn't touch them, so callers shouldn't either.
+type chargeOutcome struct {
+	invoiceID string
+	charged   []InvoiceItem
+	failed    []InvoiceItem
+}
+
+// chargeCustomer adds every not-yet-charged item to the customer's invoice and finalizes it
+// through the given Invoicer - all items must share currencyCode, since invoices can't mix
+// currencies. Both calls carry deterministic idempotency keys, so re-running this for the same
+// items is always safe. st is checked before and updated atomically after every Stripe call, so
+// the local record of what's been charged never depends on the Airtable write that follows
+// actually succeeding.
+//
+// items may include rows already charged under an earlier invoice (finalize-invoices and
+// reconcile can re-select a customer/currency pair that's a mix of stuck and already-settled
+// rows) - those are skipped entirely rather than folded into invoiceKey or overwritten with
+// whatever invoice this call happens to finalize.
+func chargeCustomer(invoicer billing.Invoicer, st *store.Store, customerID string, currencyCode string, items []InvoiceItem) (chargeOutcome, error) {
+	if len(items) <= 0 {
+		return chargeOutcome{}, nil
+	}
+
+	var toFinalize []InvoiceItem
+	var failed []InvoiceItem
+	var addErrs []string
+
+	for _, item := range items {
+		if alreadyCharged(st, item.airtableRecordID) {
+			continue
+		}
+
+		rowTotalNet := item.amount * float64(item.quantity)
+		amount := billing.ToMinorUnits(rowTotalNet, currencyCode)
+
+		lineItem := billing.LineItem{
+			IdempotencyKey: billing.IdempotencyKey(item.airtableRecordID, "item"),
+			Amount:         amount,
+			CurrencyCode:   currencyCode,
+			Description:    fmt.Sprintf("%s for %s on %s", item.item, item.property, item.dateServiced.String()),
+			VATBasisPoints: item.vatBasisPoints,
+		}
+
+		itemID, addErr := invoicer.AddLineItem(customerID, lineItem)
+		if addErr != nil {
+			log.Printf("unable to create stripe invoice item for airtable record %s - %v", item.airtableRecordID, addErr.Error())
+			failed = append(failed, item)
+			addErrs = append(addErrs, fmt.Sprintf("%s: %v", item.airtableRecordID, addErr))
+			continue
+		}
+
+		recordCharge(st, store.ChargeRecord{
+			AirtableRecordID:    item.airtableRecordID,
+			StripeInvoiceItemID: itemID,
+			ChargedAt:           time.Now(),
+			Amount:              amount,
+			Currency:            currencyCode,
+			Status:              store.StatusItemAdded,
+		})
+
+		toFinalize = append(toFinalize, item)
+	}
+
+	if len(toFinalize) == 0 {
+		if len(addErrs) > 0 {
+			return chargeOutcome{failed: failed}, fmt.Errorf("failed to add %d item(s) to stripe: %s", len(addErrs), strings.Join(addErrs, "; "))
+		}
+
+		return chargeOutcome{}, nil
+	}
+
+	invoiceKey := billing.IdempotencyKey(customerID, currencyCode, earliestServiceDate(toFinalize).Format("2006-01-02"), "invoice")
+
+	invoiceID, err := invoicer.FinalizeInvoice(customerID, currencyCode, invoiceKey)
+	if err != nil {
+		return chargeOutcome{failed: failed}, err
+	}
+
+	if st != nil {
+		for _, item := range toFinalize {
+			rec, found, getErr := st.Get(item.airtableRecordID)
+			if getErr != nil || !found {
+				rec = store.ChargeRecord{AirtableRecordID: item.airtableRecordID, Currency: currencyCode}
+			}
+
+			rec.StripeInvoiceID = invoiceID
+			rec.Status = store.StatusCharged
+			rec.ChargedAt = time.Now()
+
+			recordCharge(st, rec)
+		}
+	}
+
+	outcome := chargeOutcome{invoiceID: invoiceID, charged: toFinalize, failed: failed}
+
+	if len(addErrs) > 0 {
+		return outcome, fmt.Errorf("failed to add %d item(s) to stripe: %s", len(addErrs), strings.Join(addErrs, "; "))
+	}
+
+	return outcome, nil
+}
@@ -0,0 +1,211 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dnoberon/charger/billing"
+	"github.com/dnoberon/charger/store"
+)
+
+// mockInvoicer is a billing.Invoicer backed by in-memory state, so chargeCustomer can be
+// exercised without talking to Stripe
+type mockInvoicer struct {
+	lineItems        []billing.LineItem
+	finalizedInvoice string
+	addLineItemErr   error
+	finalizeErr      error
+}
+
+var _ billing.Invoicer = (*mockInvoicer)(nil)
+
+func (m *mockInvoicer) AddLineItem(customerID string, item billing.LineItem) (string, error) {
+	if m.addLineItemErr != nil {
+		return "", m.addLineItemErr
+	}
+
+	m.lineItems = append(m.lineItems, item)
+
+	return fmt.Sprintf("ii_%d", len(m.lineItems)), nil
+}
+
+func (m *mockInvoicer) FinalizeInvoice(customerID string, currencyCode string, idempotencyKey string) (string, error) {
+	if m.finalizeErr != nil {
+		return "", m.finalizeErr
+	}
+
+	m.finalizedInvoice = idempotencyKey
+
+	return "in_mock", nil
+}
+
+func (m *mockInvoicer) PayInvoice(invoiceID string) error {
+	return nil
+}
+
+func (m *mockInvoicer) SetInvoiceStatus(invoiceID string, status string) error {
+	return nil
+}
+
+func (m *mockInvoicer) ListOpenInvoices(customerID string) ([]billing.Invoice, error) {
+	return nil, nil
+}
+
+// openTestStore opens a charge store backed by a temporary file, closed automatically when the
+// test finishes
+func openTestStore(t *testing.T) *store.Store {
+	t.Helper()
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "charger.db"))
+	if err != nil {
+		t.Fatalf("store.Open() = %v", err)
+	}
+
+	t.Cleanup(func() { st.Close() })
+
+	return st
+}
+
+func TestChargeCustomerAddsItemsAndFinalizes(t *testing.T) {
+	st := openTestStore(t)
+	invoicer := &mockInvoicer{}
+
+	items := []InvoiceItem{
+		{airtableRecordID: "rec1", item: "cleaning", property: "Unit A", amount: 100, quantity: 1, dateServiced: time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{airtableRecordID: "rec2", item: "replacement", property: "Unit A", amount: 25, quantity: 2, dateServiced: time.Date(2026, 1, 3, 0, 0, 0, 0, time.UTC)},
+	}
+
+	outcome, err := chargeCustomer(invoicer, st, "cus_1", "usd", items)
+	if err != nil {
+		t.Fatalf("chargeCustomer() error = %v", err)
+	}
+
+	if outcome.invoiceID != "in_mock" {
+		t.Fatalf("invoiceID = %q, want %q", outcome.invoiceID, "in_mock")
+	}
+
+	if len(outcome.charged) != len(items) {
+		t.Fatalf("got %d charged items, want %d", len(outcome.charged), len(items))
+	}
+
+	if len(outcome.failed) != 0 {
+		t.Fatalf("got %d failed items, want 0", len(outcome.failed))
+	}
+
+	if len(invoicer.lineItems) != len(items) {
+		t.Fatalf("got %d line items, want %d", len(invoicer.lineItems), len(items))
+	}
+
+	for _, item := range items {
+		rec, found, err := st.Get(item.airtableRecordID)
+		if err != nil {
+			t.Fatalf("st.Get(%q) error = %v", item.airtableRecordID, err)
+		}
+
+		if !found {
+			t.Fatalf("expected a charge record for %q", item.airtableRecordID)
+		}
+
+		if rec.Status != store.StatusCharged {
+			t.Errorf("record %q status = %q, want %q", item.airtableRecordID, rec.Status, store.StatusCharged)
+		}
+
+		if rec.StripeInvoiceID != outcome.invoiceID {
+			t.Errorf("record %q invoice = %q, want %q", item.airtableRecordID, rec.StripeInvoiceID, outcome.invoiceID)
+		}
+	}
+}
+
+func TestChargeCustomerSkipsAlreadyChargedItems(t *testing.T) {
+	st := openTestStore(t)
+	invoicer := &mockInvoicer{}
+
+	item := InvoiceItem{airtableRecordID: "rec1", item: "cleaning", property: "Unit A", amount: 100, quantity: 1, dateServiced: time.Now()}
+
+	if err := st.Put(store.ChargeRecord{AirtableRecordID: "rec1", Status: store.StatusCharged, StripeInvoiceID: "in_earlier"}); err != nil {
+		t.Fatalf("st.Put() error = %v", err)
+	}
+
+	outcome, err := chargeCustomer(invoicer, st, "cus_1", "usd", []InvoiceItem{item})
+	if err != nil {
+		t.Fatalf("chargeCustomer() error = %v", err)
+	}
+
+	if len(invoicer.lineItems) != 0 {
+		t.Fatalf("got %d line items, want 0 - already-charged items shouldn't be re-added", len(invoicer.lineItems))
+	}
+
+	if len(outcome.charged) != 0 {
+		t.Fatalf("got %d charged items, want 0 - an already-charged item shouldn't be reported as newly charged", len(outcome.charged))
+	}
+
+	rec, found, err := st.Get("rec1")
+	if err != nil || !found {
+		t.Fatalf("st.Get(rec1) = %v, %v, %v", rec, found, err)
+	}
+
+	if rec.StripeInvoiceID != "in_earlier" {
+		t.Errorf("record invoice = %q, want %q - a no-op call shouldn't stomp the original invoice", rec.StripeInvoiceID, "in_earlier")
+	}
+}
+
+func TestChargeCustomerReturnsFinalizeError(t *testing.T) {
+	st := openTestStore(t)
+	invoicer := &mockInvoicer{finalizeErr: fmt.Errorf("stripe unavailable")}
+
+	item := InvoiceItem{airtableRecordID: "rec1", item: "cleaning", property: "Unit A", amount: 100, quantity: 1, dateServiced: time.Now()}
+
+	_, err := chargeCustomer(invoicer, st, "cus_1", "usd", []InvoiceItem{item})
+	if err == nil {
+		t.Fatal("chargeCustomer() error = nil, want non-nil")
+	}
+}
+
+func TestChargeCustomerExcludesFailedItemsFromInvoice(t *testing.T) {
+	st := openTestStore(t)
+
+	okItem := InvoiceItem{airtableRecordID: "rec1", item: "cleaning", property: "Unit A", amount: 100, quantity: 1, dateServiced: time.Now()}
+	failItem := InvoiceItem{airtableRecordID: "rec2", item: "replacement", property: "Unit A", amount: 50, quantity: 1, dateServiced: time.Now()}
+
+	// only failItem's AddLineItem call fails
+	invoicer := &selectiveFailInvoicer{mockInvoicer: &mockInvoicer{}, failRecordID: "rec2"}
+
+	outcome, err := chargeCustomer(invoicer, st, "cus_1", "usd", []InvoiceItem{okItem, failItem})
+	if err == nil {
+		t.Fatal("chargeCustomer() error = nil, want non-nil - one item failed to be added")
+	}
+
+	if len(outcome.charged) != 1 || outcome.charged[0].airtableRecordID != "rec1" {
+		t.Fatalf("outcome.charged = %+v, want only rec1", outcome.charged)
+	}
+
+	if len(outcome.failed) != 1 || outcome.failed[0].airtableRecordID != "rec2" {
+		t.Fatalf("outcome.failed = %+v, want only rec2", outcome.failed)
+	}
+
+	rec, found, err := st.Get("rec2")
+	if err != nil {
+		t.Fatalf("st.Get(rec2) error = %v", err)
+	}
+
+	if found && rec.Status == store.StatusCharged {
+		t.Errorf("rec2 recorded as %q, want it to never be recorded as charged", rec.Status)
+	}
+}
+
+// selectiveFailInvoicer wraps a mockInvoicer so only a chosen record's AddLineItem call fails,
+// letting tests exercise a partial failure within a single chargeCustomer call
+type selectiveFailInvoicer struct {
+	*mockInvoicer
+	failRecordID string
+}
+
+func (s *selectiveFailInvoicer) AddLineItem(customerID string, item billing.LineItem) (string, error) {
+	if item.IdempotencyKey == billing.IdempotencyKey(s.failRecordID, "item") {
+		return "", fmt.Errorf("card declined")
+	}
+
+	return s.mockInvoicer.AddLineItem(customerID, item)
+}
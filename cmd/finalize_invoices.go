@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dnoberon/charger/airtable"
+	"github.com/dnoberon/charger/billing"
+
+	"github.com/spf13/cobra"
+)
+
+var finalizeInvoicesCmd = &cobra.Command{
+	Use:   "finalize-invoices",
+	Short: "Finalize Stripe invoices for rows left in the \"charging\" state by an interrupted run",
+	RunE:  finalizeInvoices,
+}
+
+func init() {
+	rootCmd.AddCommand(finalizeInvoicesCmd)
+}
+
+func finalizeInvoices(cmd *cobra.Command, args []string) error {
+	tableName := os.Getenv("TABLENAME")
+	paidColumn := os.Getenv("PAID_COLUMN")
+	notesColumn := os.Getenv("NOTES_COLUMN")
+
+	airtableClient, err := airtable.NewAirtableClient(os.Getenv("AIRTABLE_API_KEY"), os.Getenv("AIRTABLE_BASE_ID"))
+	if err != nil {
+		return err
+	}
+
+	recs, err := fetchAllAirtableRecords(chargingInvoicesFilter())
+	if err != nil {
+		return err
+	}
+
+	// these rows already passed the due-date gate during the run that staged them as "charging"
+	customerInvoices := groupInvoiceItems(recs, 0, false)
+	if len(customerInvoices) == 0 {
+		fmt.Println("no rows stuck in the charging state")
+		return nil
+	}
+
+	invoicer := billing.NewStripeInvoicer()
+
+	chargeStore, err := openChargeStore()
+	if err != nil {
+		return err
+	}
+	defer chargeStore.Close()
+
+	for key, invoices := range customerInvoices {
+		// AddLineItem/FinalizeInvoice carry deterministic idempotency keys, so re-running this
+		// for items that already made it to Stripe before the crash is always safe
+		outcome, err := chargeCustomer(invoicer, chargeStore, key.customerID, key.currencyCode, invoices)
+		if err != nil {
+			log.Printf("error charging customer %s (%s): %v", key.customerID, key.currencyCode, err)
+		}
+
+		// only rows actually covered by outcome.invoiceID get marked paid - rows that failed
+		// AddLineItem this call are left at "charging" so the next run retries them, instead
+		// of being wrongly marked paid or permanently excluded via paid=false
+		if len(outcome.charged) > 0 {
+			updateFields := map[string]interface{}{paidColumn: "true", notesColumn: outcome.invoiceID}
+			for _, item := range outcome.charged {
+				updatedRecord := airtable.Record{ID: item.airtableRecordID, Fields: updateFields}
+				if err := airtableClient.PartialUpdate(airtable.PartialUpdateOptions{TableName: tableName}, updatedRecord); err != nil {
+					log.Printf("error updating airtable records %v", err)
+				}
+			}
+		}
+
+		for _, item := range outcome.failed {
+			updateFields := map[string]interface{}{notesColumn: fmt.Sprintf("Error charging customer through Stripe: %v", err)}
+			updatedRecord := airtable.Record{ID: item.airtableRecordID, Fields: updateFields}
+			if err := airtableClient.PartialUpdate(airtable.PartialUpdateOptions{TableName: tableName}, updatedRecord); err != nil {
+				log.Printf("error updating airtable records %v", err)
+			}
+		}
+
+		fmt.Printf("finalized invoice for customer %s (%s): %d charged, %d failed\n", key.customerID, key.currencyCode, len(outcome.charged), len(outcome.failed))
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dnoberon/charger/billing"
+
+	"github.com/spf13/cobra"
+)
+
+var listOpenInvoicesCmd = &cobra.Command{
+	Use:   "list-open-invoices",
+	Short: "List all open (finalized, unpaid) Stripe invoices",
+	RunE:  listOpenInvoices,
+}
+
+func init() {
+	rootCmd.AddCommand(listOpenInvoicesCmd)
+}
+
+func listOpenInvoices(cmd *cobra.Command, args []string) error {
+	invoicer := billing.NewStripeInvoicer()
+
+	invoices, err := invoicer.ListOpenInvoices("")
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invoices {
+		fmt.Printf("%s\tcustomer=%s\tstatus=%s\tcreated=%s\n", inv.ID, inv.CustomerID, inv.Status, time.Unix(inv.Created, 0).UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
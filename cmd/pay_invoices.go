@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/dnoberon/charger/billing"
+
+	"github.com/spf13/cobra"
+)
+
+var payInvoicesCmd = &cobra.Command{
+	Use:   "pay-invoices [customerID]",
+	Short: "Attempt to collect payment on open invoices, optionally scoped to one customer",
+	Args:  cobra.MaximumNArgs(1),
+	RunE:  payInvoices,
+}
+
+func init() {
+	rootCmd.AddCommand(payInvoicesCmd)
+}
+
+func payInvoices(cmd *cobra.Command, args []string) error {
+	var customerID string
+	if len(args) == 1 {
+		customerID = args[0]
+	}
+
+	invoicer := billing.NewStripeInvoicer()
+
+	invoices, err := invoicer.ListOpenInvoices(customerID)
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invoices {
+		if err := invoicer.PayInvoice(inv.ID); err != nil {
+			log.Printf("error paying invoice %s for customer %s: %v", inv.ID, inv.CustomerID, err)
+			continue
+		}
+
+		fmt.Printf("paid invoice %s for customer %s\n", inv.ID, inv.CustomerID)
+	}
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/dnoberon/charger/airtable"
+	"github.com/dnoberon/charger/records"
+	"github.com/dnoberon/charger/store"
+
+	"github.com/spf13/cobra"
+)
+
+// reconcileBatchSize caps how many charged records' Airtable rows are looked up per
+// FilterByFormula call, matching the page size used elsewhere for Airtable list requests
+const reconcileBatchSize = 100
+
+// airtableLister is satisfied by the Airtable client returned from airtable.NewAirtableClient
+type airtableLister interface {
+	ListFromTable(airtable.ListRecordsOptions) (airtable.ListResponse, error)
+}
+
+var reconcileCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Reconcile the local charge store against Airtable and Stripe after a crash",
+	RunE:  reconcile,
+}
+
+func init() {
+	rootCmd.AddCommand(reconcileCmd)
+}
+
+func reconcile(cmd *cobra.Command, args []string) error {
+	st, err := openChargeStore()
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	return runReconcile(st)
+}
+
+// currentPaidValues fetches the live PAID column for each of recs from Airtable, keyed by
+// Airtable record ID, so runReconcile can tell which charged records still need a write instead
+// of blindly re-pushing every one of them on every run
+func currentPaidValues(client airtableLister, tableName, paidColumn string, recs []store.ChargeRecord) (map[string]string, error) {
+	values := map[string]string{}
+
+	for start := 0; start < len(recs); start += reconcileBatchSize {
+		end := start + reconcileBatchSize
+		if end > len(recs) {
+			end = len(recs)
+		}
+
+		batch := recs[start:end]
+		formulas := make([]string, len(batch))
+		for i, rec := range batch {
+			formulas[i] = fmt.Sprintf("RECORD_ID()='%s'", rec.AirtableRecordID)
+		}
+
+		throttleAirtable()
+
+		results, err := client.ListFromTable(airtable.ListRecordsOptions{
+			TableName:       tableName,
+			Fields:          []string{paidColumn},
+			FilterByFormula: fmt.Sprintf("OR(%s)", strings.Join(formulas, ",")),
+			PageSize:        reconcileBatchSize,
+		})
+		if err != nil {
+			return values, err
+		}
+
+		for _, r := range results.Records {
+			if paid, ok := records.StringField(r.Fields, paidColumn); ok {
+				values[r.ID] = paid
+			}
+		}
+	}
+
+	return values, nil
+}
+
+// runReconcile corrects drift between the local charge store and Airtable/Stripe left behind by
+// a prior crash. Records already charged are re-pushed to Airtable only when the live PAID
+// column doesn't already say "true" - otherwise every restart would replay a full history of
+// already-reconciled writes against Airtable's rate limit. Records that only got as far as
+// adding a Stripe invoice item are recovered by delegating to finalize-invoices, which carries
+// the same idempotency keys and is therefore safe to re-run.
+func runReconcile(st *store.Store) error {
+	recs, err := st.All()
+	if err != nil {
+		return err
+	}
+
+	tableName := os.Getenv("TABLENAME")
+	paidColumn := os.Getenv("PAID_COLUMN")
+	notesColumn := os.Getenv("NOTES_COLUMN")
+
+	airtableClient, err := airtable.NewAirtableClient(os.Getenv("AIRTABLE_API_KEY"), os.Getenv("AIRTABLE_BASE_ID"))
+	if err != nil {
+		return err
+	}
+
+	var charged []store.ChargeRecord
+	var stuck int
+
+	for _, rec := range recs {
+		switch rec.Status {
+		case store.StatusCharged:
+			charged = append(charged, rec)
+		case store.StatusItemAdded:
+			stuck++
+		}
+	}
+
+	if len(charged) > 0 {
+		paidValues, err := currentPaidValues(airtableClient, tableName, paidColumn, charged)
+		if err != nil {
+			log.Printf("error checking current airtable paid status, reconciling every one of %d charged record(s) not confirmed paid: %v", len(charged), err)
+		}
+
+		for _, rec := range charged {
+			if paidValues[rec.AirtableRecordID] == "true" {
+				continue
+			}
+
+			updateFields := map[string]interface{}{paidColumn: "true", notesColumn: rec.StripeInvoiceID}
+			updatedRecord := airtable.Record{ID: rec.AirtableRecordID, Fields: updateFields}
+			if err := partialUpdate(airtableClient, tableName, updatedRecord); err != nil {
+				log.Printf("error reconciling airtable record %s: %v", rec.AirtableRecordID, err)
+			}
+		}
+	}
+
+	if stuck == 0 {
+		return nil
+	}
+
+	fmt.Printf("reconcile: %d record(s) have a Stripe invoice item but no finalized invoice, recovering via finalize-invoices\n", stuck)
+
+	return finalizeInvoices(nil, nil)
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dnoberon/charger/airtable"
+	"github.com/dnoberon/charger/store"
+)
+
+// fakeAirtableLister is an airtableLister backed by a fixed set of records, so
+// currentPaidValues can be tested without a real Airtable client
+type fakeAirtableLister struct {
+	records []airtable.Record
+	calls   int
+}
+
+func (f *fakeAirtableLister) ListFromTable(options airtable.ListRecordsOptions) (airtable.ListResponse, error) {
+	f.calls++
+	return airtable.ListResponse{Records: f.records}, nil
+}
+
+func TestCurrentPaidValues(t *testing.T) {
+	client := &fakeAirtableLister{
+		records: []airtable.Record{
+			{ID: "rec1", Fields: map[string]interface{}{"Paid": "true"}},
+			{ID: "rec2", Fields: map[string]interface{}{"Paid": "false"}},
+		},
+	}
+
+	charged := []store.ChargeRecord{
+		{AirtableRecordID: "rec1"},
+		{AirtableRecordID: "rec2"},
+	}
+
+	values, err := currentPaidValues(client, "Invoices", "Paid", charged)
+	if err != nil {
+		t.Fatalf("currentPaidValues() error = %v", err)
+	}
+
+	if values["rec1"] != "true" {
+		t.Errorf("values[rec1] = %q, want %q", values["rec1"], "true")
+	}
+
+	if values["rec2"] != "false" {
+		t.Errorf("values[rec2] = %q, want %q", values["rec2"], "false")
+	}
+
+	if client.calls != 1 {
+		t.Errorf("ListFromTable called %d times, want 1 for %d records", client.calls, len(charged))
+	}
+}
+
+func TestCurrentPaidValuesBatchesLargeRecordSets(t *testing.T) {
+	client := &fakeAirtableLister{}
+
+	charged := make([]store.ChargeRecord, reconcileBatchSize+1)
+	for i := range charged {
+		charged[i] = store.ChargeRecord{AirtableRecordID: fmt.Sprintf("rec%d", i)}
+	}
+
+	if _, err := currentPaidValues(client, "Invoices", "Paid", charged); err != nil {
+		t.Fatalf("currentPaidValues() error = %v", err)
+	}
+
+	if client.calls != 2 {
+		t.Errorf("ListFromTable called %d times, want 2 for %d records split across batches of %d", client.calls, len(charged), reconcileBatchSize)
+	}
+}
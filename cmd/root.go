@@ -0,0 +1,26 @@
+// Package cmd implements the charger CLI: a set of subcommands for running and correcting
+// the Airtable/Stripe invoicing pipeline.
+package cmd
+
+import (
+	"log"
+
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:   "charger",
+	Short: "Bill Airtable-tracked property services through Stripe",
+}
+
+func init() {
+	godotenv.Load()
+}
+
+// Execute runs the charger CLI, exiting the process with a non-zero status on error
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dnoberon/charger/airtable"
+	"github.com/dnoberon/charger/billing"
+	"github.com/dnoberon/charger/ratelimit"
+	"github.com/dnoberon/charger/store"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultWorkers  = 4
+	defaultRateRPS  = 5
+	workersEnvVar   = "CHARGER_WORKERS"
+	rateLimitEnvVar = "CHARGER_RATE_LIMIT_RPS"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run",
+	Short: "Continuously charge Airtable rows that are due through Stripe",
+	RunE:  runCharger,
+}
+
+func init() {
+	rootCmd.AddCommand(runCmd)
+}
+
+func runCharger(cmd *cobra.Command, args []string) error {
+	tableName := os.Getenv("TABLENAME")
+	paidColumn := os.Getenv("PAID_COLUMN")
+	notesColumn := os.Getenv("NOTES_COLUMN")
+
+	staleDays, err := strconv.Atoi(os.Getenv("STALE_DAYS"))
+	if err != nil {
+		staleDays = -7
+	}
+
+	workers := defaultWorkers
+	if v, err := strconv.Atoi(os.Getenv(workersEnvVar)); err == nil && v > 0 {
+		workers = v
+	}
+
+	rateRPS := float64(defaultRateRPS)
+	if v, err := strconv.ParseFloat(os.Getenv(rateLimitEnvVar), 64); err == nil && v > 0 {
+		rateRPS = v
+	}
+
+	// shared across Airtable and Stripe calls so a wide worker pool still respects both APIs'
+	// quotas - replaces the old fixed 1s sleep between customers
+	apiLimiter = ratelimit.New(rateRPS)
+
+	airtableClient, err := airtable.NewAirtableClient(os.Getenv("AIRTABLE_API_KEY"), os.Getenv("AIRTABLE_BASE_ID"))
+	if err != nil {
+		return err
+	}
+
+	invoicer := billing.NewStripeInvoicer(billing.WithRateLimiter(apiLimiter))
+
+	chargeStore, err := openChargeStore()
+	if err != nil {
+		return err
+	}
+	defer chargeStore.Close()
+
+	// correct any drift left behind by a prior crash before picking up new work
+	if err := runReconcile(chargeStore); err != nil {
+		log.Printf("error reconciling charge store on startup: %v", err)
+	}
+
+	fmt.Println("Charger Running....")
+
+	// start process loop
+	for {
+		recs, err := fetchAllAirtableRecords(dueInvoicesFilter())
+		if err != nil {
+			log.Printf("error fetching airtable records %v", err)
+		}
+
+		// each record represents a possible invoice item, keyed by customer and currency -
+		// Stripe won't allow mixed-currency invoices, so each pair is billed separately
+		customerInvoices := groupInvoiceItems(recs, staleDays, true)
+
+		// one lock per customerID, shared across that customer's currency groups - Stripe
+		// rejects concurrent pending invoice items/finalizes for the same customer even when
+		// they're for different currencies, so the worker pool can parallelize across
+		// customers but must serialize within one
+		customerLocks := make(map[string]*sync.Mutex, len(customerInvoices))
+		for key := range customerInvoices {
+			if _, ok := customerLocks[key.customerID]; !ok {
+				customerLocks[key.customerID] = &sync.Mutex{}
+			}
+		}
+
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		var summaryMu sync.Mutex
+		var summary []string
+
+		for key, invoices := range customerInvoices {
+			key, invoices := key, invoices
+			customerLock := customerLocks[key.customerID]
+
+			wg.Add(1)
+			sem <- struct{}{}
+
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				customerLock.Lock()
+				defer customerLock.Unlock()
+
+				err := chargeAndRecordCustomer(airtableClient, tableName, paidColumn, notesColumn, invoicer, chargeStore, key, invoices)
+
+				summaryMu.Lock()
+				defer summaryMu.Unlock()
+
+				if err != nil {
+					summary = append(summary, fmt.Sprintf("customer %s (%s): error: %v", key.customerID, key.currencyCode, err))
+				} else {
+					summary = append(summary, fmt.Sprintf("customer %s (%s): ok", key.customerID, key.currencyCode))
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		log.Printf("charge summary for this loop iteration (%d customer/currency groups):", len(summary))
+		for _, line := range summary {
+			log.Printf("  %s", line)
+		}
+
+		time.Sleep(60 * time.Second)
+		fmt.Println("Starting new processing loop...")
+	}
+}
+
+// chargeAndRecordCustomer stages the "charging" marker, charges the customer/currency pair
+// through invoicer, and writes the resulting paid/notes columns back to Airtable. It returns
+// the charge error (if any) so callers can aggregate per-customer failures.
+func chargeAndRecordCustomer(airtableClient partialUpdater, tableName, paidColumn, notesColumn string, invoicer billing.Invoicer, st *store.Store, key customerCurrency, invoices []InvoiceItem) error {
+	// sort the invoices by date serviced prior to charging them to stripe - the invoice will
+	// look a lot cleaner this way
+	dateSortedInvoices := make(invoiceByTime, 0, len(invoices))
+	for _, d := range invoices {
+		dateSortedInvoices = append(dateSortedInvoices, d)
+	}
+
+	sort.Sort(dateSortedInvoices)
+
+	// stage a "charging" marker before touching Stripe so a crash mid-run doesn't
+	// re-invoice these rows on restart - dueInvoicesFilter excludes this status
+	stagingFields := map[string]interface{}{paidColumn: "charging"}
+	for _, item := range invoices {
+		staged := airtable.Record{ID: item.airtableRecordID, Fields: stagingFields}
+		if err := partialUpdate(airtableClient, tableName, staged); err != nil {
+			log.Printf("error staging charging marker for airtable record %s: %v", item.airtableRecordID, err)
+		}
+	}
+
+	outcome, chargeErr := chargeCustomer(invoicer, st, key.customerID, key.currencyCode, dateSortedInvoices)
+	if chargeErr != nil {
+		log.Printf("error charging customer %s (%s): %v", key.customerID, key.currencyCode, chargeErr)
+	}
+
+	// only rows actually covered by outcome.invoiceID get marked paid - rows that failed
+	// AddLineItem this call are left at "charging" so the next finalize-invoices run retries
+	// them, instead of being wrongly marked paid or permanently excluded via paid=false
+	if len(outcome.charged) > 0 {
+		updateFields := map[string]interface{}{paidColumn: "true", notesColumn: outcome.invoiceID}
+		for _, item := range outcome.charged {
+			updatedRecord := airtable.Record{ID: item.airtableRecordID, Fields: updateFields}
+			if err := partialUpdate(airtableClient, tableName, updatedRecord); err != nil {
+				log.Printf("error updating airtable records %v", err)
+			}
+		}
+	}
+
+	for _, item := range outcome.failed {
+		updateFields := map[string]interface{}{notesColumn: fmt.Sprintf("Error charging customer through Stripe: %v", chargeErr)}
+		updatedRecord := airtable.Record{ID: item.airtableRecordID, Fields: updateFields}
+		if err := partialUpdate(airtableClient, tableName, updatedRecord); err != nil {
+			log.Printf("error updating airtable records %v", err)
+		}
+	}
+
+	return chargeErr
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dnoberon/charger/billing"
+
+	"github.com/spf13/cobra"
+)
+
+var setInvoiceStatusDryRun bool
+
+var setInvoiceStatusCmd = &cobra.Command{
+	Use:   "set-invoice-status <start YYYY-MM> <end YYYY-MM> <status>",
+	Short: "Void or mark uncollectible all open invoices created within a UTC period",
+	Long: "Pages through open Stripe invoices created on or after the start month and before " +
+		"the end month (both UTC) and sets each to the given status (void or uncollectible). " +
+		"Use this to correct Airtable/Stripe drift without clicking through the Stripe dashboard.",
+	Args: cobra.ExactArgs(3),
+	RunE: setInvoiceStatus,
+}
+
+func init() {
+	setInvoiceStatusCmd.Flags().BoolVar(&setInvoiceStatusDryRun, "dry-run", false, "log intended changes without calling Stripe")
+	rootCmd.AddCommand(setInvoiceStatusCmd)
+}
+
+func setInvoiceStatus(cmd *cobra.Command, args []string) error {
+	start, err := time.Parse("2006-01", args[0])
+	if err != nil {
+		return fmt.Errorf("invalid start period %q: %w", args[0], err)
+	}
+
+	end, err := time.Parse("2006-01", args[1])
+	if err != nil {
+		return fmt.Errorf("invalid end period %q: %w", args[1], err)
+	}
+
+	status := args[2]
+	if status != "void" && status != "uncollectible" {
+		return fmt.Errorf("unsupported status %q, must be void or uncollectible", status)
+	}
+
+	invoicer := billing.NewStripeInvoicer()
+
+	invoices, err := invoicer.ListOpenInvoices("")
+	if err != nil {
+		return err
+	}
+
+	for _, inv := range invoices {
+		created := time.Unix(inv.Created, 0).UTC()
+		if created.Before(start) || !created.Before(end) {
+			continue
+		}
+
+		if setInvoiceStatusDryRun {
+			fmt.Printf("dry-run: would set invoice %s (customer %s, created %s) to %s\n", inv.ID, inv.CustomerID, created.Format(time.RFC3339), status)
+			continue
+		}
+
+		if err := invoicer.SetInvoiceStatus(inv.ID, status); err != nil {
+			log.Printf("error setting invoice %s to %s: %v", inv.ID, status, err)
+			continue
+		}
+
+		fmt.Printf("set invoice %s (customer %s) to %s\n", inv.ID, inv.CustomerID, status)
+	}
+
+	return nil
+}
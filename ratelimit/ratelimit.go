@@ -0,0 +1,65 @@
+// Package ratelimit provides a minimal token-bucket limiter used to keep charger's calls to
+// Airtable and Stripe within their API quotas once work is parallelized across customers.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Limiter is a token-bucket rate limiter safe for concurrent use. It allows bursts up to its
+// configured rate and refills continuously at that same rate per second.
+type Limiter struct {
+	mu         sync.Mutex
+	tokens     float64
+	max        float64
+	refillRate float64 // tokens per second
+	last       time.Time
+}
+
+// New returns a Limiter that allows up to ratePerSecond operations per second, with bursts up
+// to that many tokens (at least one). A ratePerSecond <= 0 disables throttling - Wait returns
+// immediately.
+func New(ratePerSecond float64) *Limiter {
+	burst := ratePerSecond
+	if burst > 0 && burst < 1 {
+		burst = 1
+	}
+
+	return &Limiter{
+		tokens:     burst,
+		max:        burst,
+		refillRate: ratePerSecond,
+		last:       time.Now(),
+	}
+}
+
+// Wait blocks until a token is available, consuming it before returning
+func (l *Limiter) Wait() {
+	if l == nil || l.refillRate <= 0 {
+		return
+	}
+
+	for {
+		l.mu.Lock()
+
+		now := time.Now()
+		elapsed := now.Sub(l.last).Seconds()
+		l.tokens += elapsed * l.refillRate
+		if l.tokens > l.max {
+			l.tokens = l.max
+		}
+		l.last = now
+
+		if l.tokens >= 1 {
+			l.tokens--
+			l.mu.Unlock()
+			return
+		}
+
+		wait := time.Duration((1 - l.tokens) / l.refillRate * float64(time.Second))
+		l.mu.Unlock()
+
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,61 @@
+// Package records provides typed accessors over Airtable's loosely-typed Fields map, in place
+// of ad-hoc reflect.TypeOf switches scattered through the caller.
+package records
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// StringField extracts a field and stringifies it, regardless of its underlying Airtable type
+func StringField(fields map[string]interface{}, column string) (string, bool) {
+	val, ok := fields[column]
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf("%v", val), true
+}
+
+// RollupString extracts a field that may be either a plain string or an Airtable rollup -
+// which the API represents as a slice - returning the first element stringified either way
+func RollupString(fields map[string]interface{}, column string) (string, bool) {
+	val, ok := fields[column]
+	if !ok {
+		return "", false
+	}
+
+	rt := reflect.TypeOf(val)
+	switch rt.Kind() {
+	case reflect.Slice, reflect.Array:
+		c, ok := val.([]interface{})
+		if !ok || len(c) == 0 {
+			return "", false
+		}
+
+		return fmt.Sprintf("%v", c[0]), true
+	default:
+		return fmt.Sprintf("%v", val), true
+	}
+}
+
+// NumberField extracts a numeric field - Airtable's API always represents numbers as float64
+func NumberField(fields map[string]interface{}, column string) (float64, bool) {
+	val, ok := fields[column]
+	if !ok {
+		return 0, false
+	}
+
+	n, ok := val.(float64)
+	return n, ok
+}
+
+// IntField extracts a numeric field and truncates it to an int64
+func IntField(fields map[string]interface{}, column string) (int64, bool) {
+	n, ok := NumberField(fields, column)
+	if !ok {
+		return 0, false
+	}
+
+	return int64(n), true
+}
@@ -0,0 +1,112 @@
+// Package store persists per-Airtable-row charge state in a small embedded database, so a
+// failed Airtable write after a successful Stripe call can never cause a double-charge on the
+// next run - Airtable's PAID column alone can't be trusted to reflect what Stripe actually did.
+package store
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+const chargesBucket = "charges"
+
+// Status values recorded for a ChargeRecord
+const (
+	// StatusItemAdded means the Stripe invoice item was created but the invoice covering it
+	// hasn't been finalized yet - recoverable via the finalize-invoices command
+	StatusItemAdded = "item_added"
+	// StatusCharged means the invoice was finalized - StripeInvoiceID is set
+	StatusCharged = "charged"
+)
+
+// ChargeRecord is the local bookkeeping kept for each Airtable row that's been charged
+type ChargeRecord struct {
+	AirtableRecordID    string    `json:"airtableRecordID"`
+	StripeInvoiceID     string    `json:"stripeInvoiceID"`
+	StripeInvoiceItemID string    `json:"stripeInvoiceItemID"`
+	ChargedAt           time.Time `json:"chargedAt"`
+	Amount              int64     `json:"amount"`
+	Currency            string    `json:"currency"`
+	Status              string    `json:"status"`
+}
+
+// Store is a small embedded key/value store of ChargeRecords, keyed by Airtable record ID
+type Store struct {
+	db *bbolt.DB
+}
+
+// Open opens (creating if necessary) the BoltDB file at path
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(chargesBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db: db}, nil
+}
+
+// Close releases the underlying database file
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Get returns the ChargeRecord for airtableRecordID, if one exists
+func (s *Store) Get(airtableRecordID string) (ChargeRecord, bool, error) {
+	var rec ChargeRecord
+	var found bool
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(chargesBucket)).Get([]byte(airtableRecordID))
+		if data == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(data, &rec)
+	})
+
+	return rec, found, err
+}
+
+// Put atomically creates or replaces the ChargeRecord for rec.AirtableRecordID
+func (s *Store) Put(rec ChargeRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(chargesBucket)).Put([]byte(rec.AirtableRecordID), data)
+	})
+}
+
+// All returns every ChargeRecord currently in the store
+func (s *Store) All() ([]ChargeRecord, error) {
+	var recs []ChargeRecord
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(chargesBucket)).ForEach(func(_, v []byte) error {
+			var rec ChargeRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return err
+			}
+
+			recs = append(recs, rec)
+
+			return nil
+		})
+	})
+
+	return recs, err
+}